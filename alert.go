@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	alertKindMinTemp       = "min_temp"
+	alertKindMaxTemp       = "max_temp"
+	alertKindStuck         = "stuck"
+	alertKindScrapeFailure = "scrape_failure"
+)
+
+// alertEvent describes a single threshold violation, passed to every
+// configured notifier when an alert first fires.
+type alertEvent struct {
+	Device     string
+	SensorID   string
+	SensorName string
+	Kind       string
+	Value      float64
+	Threshold  float64
+	Since      time.Time
+}
+
+// notifier delivers an alertEvent to some external system.
+type notifier interface {
+	Notify(a alertEvent) error
+}
+
+// logNotifier writes alerts to the collector's zap logger.
+type logNotifier struct {
+	logger *zap.Logger
+}
+
+func (n *logNotifier) Notify(a alertEvent) error {
+	n.logger.Warn("alert fired",
+		zap.String("sensor_id", a.SensorID),
+		zap.String("sensor_name", a.SensorName),
+		zap.String("kind", a.Kind),
+		zap.Float64("value", a.Value),
+		zap.Float64("threshold", a.Threshold),
+		zap.Duration("duration", time.Since(a.Since)),
+	)
+	return nil
+}
+
+// webhookPayload is the JSON body POSTed by webhookNotifier.
+type webhookPayload struct {
+	Device     string  `json:"device"`
+	SensorID   string  `json:"sensor_id"`
+	SensorName string  `json:"sensor_name"`
+	Kind       string  `json:"kind"`
+	Value      float64 `json:"value"`
+	Threshold  float64 `json:"threshold"`
+	Duration   string  `json:"duration"`
+}
+
+// webhookNotifier POSTs a JSON payload describing the alert to url.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookNotifier(url string) *webhookNotifier {
+	return &webhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (n *webhookNotifier) Notify(a alertEvent) error {
+	payload := webhookPayload{
+		Device:     a.Device,
+		SensorID:   a.SensorID,
+		SensorName: a.SensorName,
+		Kind:       a.Kind,
+		Value:      a.Value,
+		Threshold:  a.Threshold,
+		Duration:   time.Since(a.Since).String(),
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal webhook payload")
+	}
+
+	res, err := n.client.Post(n.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "failed to post webhook")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return errors.Errorf("webhook returned unexpected status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// sensorThreshold holds the alert bounds configured for a single sensor.
+type sensorThreshold struct {
+	minTemp       *float64
+	maxTemp       *float64
+	stuckDuration time.Duration
+}
+
+// buildThresholds converts the config file's sensor list into a map
+// keyed by sensor ID for fast lookup during scrapes.
+func buildThresholds(sensors []sensorAlertConfig) (map[string]sensorThreshold, error) {
+	thresholds := make(map[string]sensorThreshold, len(sensors))
+
+	for _, s := range sensors {
+		var stuck time.Duration
+
+		if s.StuckDuration != "" {
+			d, err := time.ParseDuration(s.StuckDuration)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid stuck_duration for sensor %q", s.ID)
+			}
+			stuck = d
+		}
+
+		thresholds[s.ID] = sensorThreshold{
+			minTemp:       s.MinTemp,
+			maxTemp:       s.MaxTemp,
+			stuckDuration: stuck,
+		}
+	}
+
+	return thresholds, nil
+}
+
+// alertManager evaluates sensor readings and scrape health against
+// configured thresholds, notifying configured sinks on every
+// min_temp/max_temp/stuck/scrape_failure transition and tracking
+// active/fired counts as Prometheus metrics.
+type alertManager struct {
+	sync.Mutex
+	device              string
+	thresholds          map[string]sensorThreshold
+	notifiers           []notifier
+	scrapeFailureWindow time.Duration
+	failingSince        time.Time
+	lastValues          map[string]float64
+	lastChanged         map[string]time.Time
+	active              map[string]time.Time
+	activeMetric        *prometheus.GaugeVec
+	firedMetric         *prometheus.CounterVec
+	logger              *zap.Logger
+}
+
+func newAlertManager(device string, thresholds map[string]sensorThreshold, scrapeFailureWindow time.Duration, notifiers []notifier, logger *zap.Logger) *alertManager {
+	return &alertManager{
+		device:              device,
+		thresholds:          thresholds,
+		notifiers:           notifiers,
+		scrapeFailureWindow: scrapeFailureWindow,
+		lastValues:          make(map[string]float64),
+		lastChanged:         make(map[string]time.Time),
+		active:              make(map[string]time.Time),
+		activeMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "alert_active",
+			Help:        "whether an alert is currently active for a sensor",
+			ConstLabels: prometheus.Labels{"device": device},
+		}, []string{"sensor", "kind"}),
+		firedMetric: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Name:        "alert_fired_total",
+			Help:        "number of times an alert has fired for a sensor",
+			ConstLabels: prometheus.Labels{"device": device},
+		}, []string{"sensor", "kind"}),
+		logger: logger,
+	}
+}
+
+func (am *alertManager) Describe(ch chan<- *prometheus.Desc) {
+	am.activeMetric.Describe(ch)
+	am.firedMetric.Describe(ch)
+}
+
+func (am *alertManager) Collect(ch chan<- prometheus.Metric) {
+	am.activeMetric.Collect(ch)
+	am.firedMetric.Collect(ch)
+}
+
+// checkSensors evaluates the latest sensor readings against the
+// configured thresholds. It must be called with the owning
+// collector's data already up to date.
+func (am *alertManager) checkSensors(sensors map[string]sensor, now time.Time) {
+	am.Lock()
+
+	var events []alertEvent
+
+	for id, t := range am.thresholds {
+		s, ok := sensors[id]
+		if !ok {
+			continue
+		}
+
+		if last, seen := am.lastValues[id]; !seen || last != s.Temp {
+			am.lastValues[id] = s.Temp
+			am.lastChanged[id] = now
+		}
+
+		if t.minTemp != nil && s.Temp < *t.minTemp {
+			events = appendEvent(events, am.fire(id, s.Name, alertKindMinTemp, s.Temp, *t.minTemp, now))
+		} else {
+			am.clear(id, alertKindMinTemp)
+		}
+
+		if t.maxTemp != nil && s.Temp > *t.maxTemp {
+			events = appendEvent(events, am.fire(id, s.Name, alertKindMaxTemp, s.Temp, *t.maxTemp, now))
+		} else {
+			am.clear(id, alertKindMaxTemp)
+		}
+
+		if t.stuckDuration > 0 && now.Sub(am.lastChanged[id]) >= t.stuckDuration {
+			events = appendEvent(events, am.fire(id, s.Name, alertKindStuck, s.Temp, 0, am.lastChanged[id]))
+		} else {
+			am.clear(id, alertKindStuck)
+		}
+	}
+
+	am.Unlock()
+
+	am.notify(events)
+}
+
+// checkScrape tracks how long scraping the device has been failing and
+// fires a scrape_failure alert once scrapeFailureWindow is exceeded.
+func (am *alertManager) checkScrape(ok bool, now time.Time) {
+	am.Lock()
+
+	if ok {
+		am.failingSince = time.Time{}
+		am.clear("", alertKindScrapeFailure)
+		am.Unlock()
+		return
+	}
+
+	if am.failingSince.IsZero() {
+		am.failingSince = now
+	}
+
+	var events []alertEvent
+	if am.scrapeFailureWindow > 0 && now.Sub(am.failingSince) >= am.scrapeFailureWindow {
+		events = appendEvent(events, am.fire("", "", alertKindScrapeFailure, 0, 0, am.failingSince))
+	}
+
+	am.Unlock()
+
+	am.notify(events)
+}
+
+// appendEvent appends e to events if e is non-nil. It exists so
+// call sites can inline a fire() call without an intermediate
+// if-statement.
+func appendEvent(events []alertEvent, e *alertEvent) []alertEvent {
+	if e == nil {
+		return events
+	}
+	return append(events, *e)
+}
+
+// fire and clear assume am is already locked. Neither does anything
+// that can block, so notifying configured sinks about a fired alert
+// happens separately, via notify, after am's lock has been released.
+
+func (am *alertManager) fire(sensorID, sensorName, kind string, value, threshold float64, since time.Time) *alertEvent {
+	k := sensorID + "|" + kind
+
+	if _, active := am.active[k]; active {
+		return nil
+	}
+
+	am.active[k] = since
+	am.activeMetric.WithLabelValues(sensorID, kind).Set(1)
+	am.firedMetric.WithLabelValues(sensorID, kind).Inc()
+
+	return &alertEvent{
+		Device:     am.device,
+		SensorID:   sensorID,
+		SensorName: sensorName,
+		Kind:       kind,
+		Value:      value,
+		Threshold:  threshold,
+		Since:      since,
+	}
+}
+
+// notify dispatches events to every configured notifier. It must be
+// called without am.Mutex held: notifiers such as webhookNotifier
+// perform blocking network I/O, and every /metrics scrape and API
+// request blocks on the owning collector's mutex, which is held
+// while checkSensors/checkScrape run.
+func (am *alertManager) notify(events []alertEvent) {
+	for _, event := range events {
+		for _, n := range am.notifiers {
+			if err := n.Notify(event); err != nil {
+				am.logger.Error("failed to send alert notification",
+					zap.Error(err),
+					zap.String("kind", event.Kind),
+				)
+			}
+		}
+	}
+}
+
+func (am *alertManager) clear(sensorID, kind string) {
+	k := sensorID + "|" + kind
+
+	if _, active := am.active[k]; !active {
+		return
+	}
+
+	delete(am.active, k)
+	am.activeMetric.WithLabelValues(sensorID, kind).Set(0)
+}
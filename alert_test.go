@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// blockingNotifier blocks in Notify until release is closed, simulating
+// a slow or unreachable webhook endpoint.
+type blockingNotifier struct {
+	release chan struct{}
+	calls   int
+	mu      sync.Mutex
+}
+
+func (n *blockingNotifier) Notify(a alertEvent) error {
+	n.mu.Lock()
+	n.calls++
+	n.mu.Unlock()
+	<-n.release
+	return nil
+}
+
+// recordingNotifier records every event it is asked to deliver.
+type recordingNotifier struct {
+	events []alertEvent
+}
+
+func (n *recordingNotifier) Notify(a alertEvent) error {
+	n.events = append(n.events, a)
+	return nil
+}
+
+func TestCheckSensorsThresholds(t *testing.T) {
+	minTemp, maxTemp := 10.0, 90.0
+
+	tests := []struct {
+		name     string
+		temp     float64
+		wantKind string
+	}{
+		{name: "below min fires min_temp", temp: 5, wantKind: alertKindMinTemp},
+		{name: "above max fires max_temp", temp: 95, wantKind: alertKindMaxTemp},
+		{name: "within range fires nothing", temp: 50, wantKind: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &recordingNotifier{}
+			am := newAlertManager("test", map[string]sensorThreshold{
+				"1": {minTemp: &minTemp, maxTemp: &maxTemp},
+			}, 0, []notifier{n}, zap.NewNop())
+
+			am.checkSensors(map[string]sensor{"1": {ID: "1", Name: "a", Temp: tt.temp}}, time.Now())
+
+			if tt.wantKind == "" {
+				if len(n.events) != 0 {
+					t.Fatalf("expected no alert, got %+v", n.events)
+				}
+				return
+			}
+
+			if len(n.events) != 1 || n.events[0].Kind != tt.wantKind {
+				t.Fatalf("expected a single %s alert, got %+v", tt.wantKind, n.events)
+			}
+		})
+	}
+}
+
+func TestCheckSensorsStuck(t *testing.T) {
+	n := &recordingNotifier{}
+	am := newAlertManager("test", map[string]sensorThreshold{
+		"1": {stuckDuration: time.Minute},
+	}, 0, []notifier{n}, zap.NewNop())
+
+	start := time.Now()
+	am.checkSensors(map[string]sensor{"1": {ID: "1", Name: "a", Temp: 42}}, start)
+	if len(n.events) != 0 {
+		t.Fatalf("expected no alert on first reading, got %+v", n.events)
+	}
+
+	// same value, but past the stuck duration: should fire
+	am.checkSensors(map[string]sensor{"1": {ID: "1", Name: "a", Temp: 42}}, start.Add(2*time.Minute))
+	if len(n.events) != 1 || n.events[0].Kind != alertKindStuck {
+		t.Fatalf("expected a stuck alert, got %+v", n.events)
+	}
+
+	// value changes: stuck alert should clear and not re-fire
+	am.checkSensors(map[string]sensor{"1": {ID: "1", Name: "a", Temp: 43}}, start.Add(3*time.Minute))
+	if len(n.events) != 1 {
+		t.Fatalf("expected no new alert once the value changes, got %+v", n.events)
+	}
+}
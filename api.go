@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// apiServer exposes the current sensor/blower snapshot and sample
+// history over a small JSON HTTP API, for dashboards and mobile
+// clients that would rather not stand up Prometheus and Grafana.
+type apiServer struct {
+	daemon *daemon
+}
+
+func (a *apiServer) register(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/sensors", a.handleSensors)
+	mux.HandleFunc("/api/v1/sensors/", a.handleSensorHistory)
+	mux.HandleFunc("/api/v1/status", a.handleStatus)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (a *apiServer) collectorForRequest(r *http.Request) *collector {
+	return a.daemon.current().byName(r.URL.Query().Get("device"))
+}
+
+func (a *apiServer) handleSensors(w http.ResponseWriter, r *http.Request) {
+	c := a.collectorForRequest(r)
+	if c == nil {
+		http.Error(w, "unknown device", http.StatusNotFound)
+		return
+	}
+
+	c.Lock()
+	sensors := make([]sensor, 0, len(c.sensors))
+	for _, s := range c.sensors {
+		sensors = append(sensors, s)
+	}
+	c.Unlock()
+
+	writeJSON(w, sensors)
+}
+
+func (a *apiServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	c := a.collectorForRequest(r)
+	if c == nil {
+		http.Error(w, "unknown device", http.StatusNotFound)
+		return
+	}
+
+	c.Lock()
+	status := struct {
+		Device  string            `json:"device"`
+		Sensors map[string]sensor `json:"sensors"`
+		Blowers map[string]blower `json:"blowers"`
+	}{
+		Device:  c.device,
+		Sensors: c.sensors,
+		Blowers: c.blowers,
+	}
+	c.Unlock()
+
+	writeJSON(w, status)
+}
+
+// handleSensorHistory serves /api/v1/sensors/{id}/history?since=...
+func (a *apiServer) handleSensorHistory(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/v1/sensors/")
+	id := strings.TrimSuffix(rest, "/history")
+	if id == "" || id == rest {
+		http.NotFound(w, r)
+		return
+	}
+
+	c := a.collectorForRequest(r)
+	if c == nil {
+		http.Error(w, "unknown device", http.StatusNotFound)
+		return
+	}
+
+	var since time.Time
+	if s := r.URL.Query().Get("since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "invalid since parameter, expected an RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+
+	if c.history == nil {
+		writeJSON(w, []sensorHistoryPoint{})
+		return
+	}
+
+	writeJSON(w, c.history.sensorSince(id, since))
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+type sensor struct {
+	ID     string  `json:"id"`
+	Name   string  `json:"name"`
+	Temp   float64 `json:"tc"`
+	Blower *string `json:"blower"`
+}
+
+type blower struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	On   int    `json:"on"`
+}
+
+type stokerResponse struct {
+	Stoker struct {
+		Sensors []sensor `json:"sensors"`
+		Blowers []blower `json:"blowers"`
+	} `json:"stoker"`
+}
+
+// collector scrapes a single Stoker unit in the background and
+// exposes its last known state as Prometheus metrics.
+type collector struct {
+	sync.Mutex
+	device            string
+	interval          time.Duration
+	client            *http.Client
+	stokerURL         *url.URL
+	sensors           map[string]sensor
+	sensorGauge       *prometheus.GaugeVec
+	blowers           map[string]blower
+	blowerGauge       *prometheus.GaugeVec
+	collections       int64
+	collectionMetrics *prometheus.Desc
+	scrapeErrors      *prometheus.CounterVec
+	scrapeDuration    prometheus.Histogram
+	scrapeSuccess     prometheus.Gauge
+	alerts            *alertManager
+	history           *history
+	logger            *zap.Logger
+}
+
+const metricsNamespace = "stoker"
+
+func newFuncMetric(metricName string, docString string, labels []string, constLabels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", metricName),
+		docString, labels, constLabels,
+	)
+}
+
+// newCollector creates a collector for a single Stoker unit. device is
+// a friendly name used to label this unit's metrics.
+func newCollector(device string, stokerURL string) (*collector, error) {
+	u, err := url.Parse(stokerURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse url %q", stokerURL)
+	}
+
+	c := &collector{
+		device:    device,
+		client:    &http.Client{},
+		interval:  time.Second * 10,
+		stokerURL: u,
+		sensors:   make(map[string]sensor),
+		sensorGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "sensor_temperature",
+			Help:      "sensor temperature",
+		}, []string{"device", "id", "name", "blower"}),
+		blowers: make(map[string]blower),
+		blowerGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "blower_state",
+			Help:      "blower state",
+		}, []string{"device", "id", "name"}),
+		collectionMetrics: newFuncMetric("collections_total", "number of times data has been collected", nil, prometheus.Labels{"device": device}),
+		scrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Name:        "scrape_errors_total",
+			Help:        "number of errors while scraping the stoker unit, by reason",
+			ConstLabels: prometheus.Labels{"device": device},
+		}, []string{"reason"}),
+		scrapeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   metricsNamespace,
+			Name:        "scrape_duration_seconds",
+			Help:        "time taken to scrape the stoker unit",
+			ConstLabels: prometheus.Labels{"device": device},
+			Buckets:     []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30},
+		}),
+		scrapeSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "scrape_success",
+			Help:        "whether the last scrape of the stoker unit succeeded",
+			ConstLabels: prometheus.Labels{"device": device},
+		}),
+	}
+
+	l, err := zap.NewProduction()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create logger")
+	}
+
+	c.logger = l.With(zap.String("device", device))
+	return c, nil
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.collectionMetrics
+	c.scrapeErrors.Describe(ch)
+	c.scrapeDuration.Describe(ch)
+	c.scrapeSuccess.Describe(ch)
+	c.sensorGauge.Describe(ch)
+	c.blowerGauge.Describe(ch)
+
+	if c.alerts != nil {
+		c.alerts.Describe(ch)
+	}
+}
+
+// scrapeErrorReason classifies why a scrape of the Stoker unit failed,
+// for the stoker_scrape_errors_total{reason=...} counter.
+type scrapeErrorReason string
+
+const (
+	reasonTimeout       scrapeErrorReason = "timeout"
+	reasonNetwork       scrapeErrorReason = "network"
+	reasonHTTPStatus    scrapeErrorReason = "http_status"
+	reasonDecode        scrapeErrorReason = "decode"
+	reasonEmptyResponse scrapeErrorReason = "empty_response"
+)
+
+// scrapeError wraps an error from getStokerStatus with the reason it
+// should be counted under. It intentionally does not implement Cause,
+// so errors.Cause(err) on a wrapped scrapeError stops here.
+type scrapeError struct {
+	reason scrapeErrorReason
+	err    error
+}
+
+func (e *scrapeError) Error() string {
+	return e.err.Error()
+}
+
+func (c *collector) getStokerStatus() (*stokerResponse, error) {
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        c.stokerURL,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       c.stokerURL.Host,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req = req.WithContext(ctx)
+
+	res, err := c.client.Do(req)
+
+	if err != nil {
+		reason := reasonNetwork
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			reason = reasonTimeout
+		}
+		return nil, &scrapeError{reason: reason, err: errors.Wrap(err, "http request failed")}
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, &scrapeError{reason: reasonHTTPStatus, err: errors.Errorf("unexpected HTTP status %d", res.StatusCode)}
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, &scrapeError{reason: reasonDecode, err: errors.Wrap(err, "failed to read response body")}
+	}
+
+	var s stokerResponse
+
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, &scrapeError{reason: reasonDecode, err: errors.Wrap(err, "failed to unmarshal response body")}
+	}
+
+	// ensure we have valid data
+	if len(s.Stoker.Sensors) == 0 {
+		return nil, &scrapeError{reason: reasonEmptyResponse, err: errors.New("no sensors found")}
+	}
+
+	return &s, nil
+
+}
+
+var nameLabelRegex = regexp.MustCompile("[^a-z0-9_]+")
+
+func cleanName(in string) string {
+	in = strings.Replace(in, " ", "_", -1)
+	in = strings.ToLower(in)
+	return nameLabelRegex.ReplaceAllString(in, "")
+}
+
+func (c *collector) recordMetrics() error {
+	s, err := c.getStokerStatus()
+	if err != nil {
+		return errors.Wrap(err, "failed to get stoker status")
+	}
+
+	sensors := make(map[string]sensor, len(s.Stoker.Sensors))
+
+	for _, v := range s.Stoker.Sensors {
+		if v.ID == "" {
+			// this should never happen
+			continue
+		}
+		v.Name = cleanName(v.Name)
+		// make a copy
+		sensors[v.ID] = v
+	}
+
+	blowers := make(map[string]blower, len(s.Stoker.Blowers))
+	for _, v := range s.Stoker.Blowers {
+		if v.ID == "" {
+			// this should never happen
+			continue
+		}
+		v.Name = cleanName(v.Name)
+		// make a copy
+		blowers[v.ID] = v
+	}
+
+	c.Lock()
+
+	// sensors and blowers that have disappeared from this response, or
+	// that kept their ID but changed the fields that make up their
+	// label set (e.g. a rename), stop being reported under their old
+	// labels rather than lingering at their last value forever
+	for id, v := range c.sensors {
+		if nv, ok := sensors[id]; !ok || sensorLabelKey(nv) != sensorLabelKey(v) {
+			c.sensorGauge.Delete(sensorLabels(c.device, v))
+		}
+	}
+
+	for id, v := range c.blowers {
+		if nv, ok := blowers[id]; !ok || blowerLabelKey(nv) != blowerLabelKey(v) {
+			c.blowerGauge.Delete(blowerLabels(c.device, v))
+		}
+	}
+
+	for _, v := range sensors {
+		c.sensorGauge.With(sensorLabels(c.device, v)).Set(v.Temp)
+	}
+
+	for _, v := range blowers {
+		c.blowerGauge.With(blowerLabels(c.device, v)).Set(float64(v.On))
+	}
+
+	if c.history != nil {
+		c.history.add(sensors, time.Now())
+	}
+
+	// just set to the new values. we do not need to merge
+	c.sensors = sensors
+	c.blowers = blowers
+
+	c.Unlock()
+
+	// checkSensors may notify external sinks (e.g. a webhook), which can
+	// block for several seconds; never do that while holding c.Mutex,
+	// since every /metrics scrape and API request also takes it.
+	if c.alerts != nil {
+		c.alerts.checkSensors(sensors, time.Now())
+	}
+
+	return nil
+}
+
+func sensorLabels(device string, v sensor) prometheus.Labels {
+	blower := ""
+	if v.Blower != nil {
+		blower = *v.Blower
+	}
+	return prometheus.Labels{"device": device, "id": v.ID, "name": v.Name, "blower": blower}
+}
+
+func blowerLabels(device string, v blower) prometheus.Labels {
+	return prometheus.Labels{"device": device, "id": v.ID, "name": v.Name}
+}
+
+// sensorLabelKey and blowerLabelKey encode the fields of v that feed
+// into its Prometheus label set, so callers can tell whether a sensor
+// or blower with the same ID now needs a different set of labels.
+func sensorLabelKey(v sensor) string {
+	blower := ""
+	if v.Blower != nil {
+		blower = *v.Blower
+	}
+	return v.Name + "|" + blower
+}
+
+func blowerLabelKey(v blower) string {
+	return v.Name
+}
+
+func doMetrics(c *collector) {
+	start := time.Now()
+	err := c.recordMetrics()
+	c.scrapeDuration.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		reason := reasonFor(err)
+		c.scrapeErrors.WithLabelValues(string(reason)).Inc()
+		c.scrapeSuccess.Set(0)
+		c.logger.Error(
+			"failed to record metrics",
+			zap.Error(err),
+			zap.String("reason", string(reason)),
+		)
+	} else {
+		c.scrapeSuccess.Set(1)
+	}
+
+	if c.alerts != nil {
+		c.alerts.checkScrape(err == nil, time.Now())
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.collections++
+}
+
+// reasonFor extracts the scrapeErrorReason getStokerStatus attached to
+// err, if any. err may be wrapped by recordMetrics, so we unwrap with
+// errors.Cause rather than a direct type assertion.
+func reasonFor(err error) scrapeErrorReason {
+	if se, ok := errors.Cause(err).(*scrapeError); ok {
+		return se.reason
+	}
+	return "unknown"
+}
+
+func (c *collector) loop(ctx context.Context) {
+	doMetrics(c)
+
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			doMetrics(c)
+		}
+	}
+}
+
+// I don't want to lock the collector the entire time I'm waiting
+// oon the channel. this is probably not needed
+func (c *collector) createMetrics() []prometheus.Metric {
+	c.Lock()
+	defer c.Unlock()
+
+	var metrics []prometheus.Metric
+
+	m, err := prometheus.NewConstMetric(
+		c.collectionMetrics,
+		prometheus.CounterValue,
+		float64(c.collections),
+	)
+
+	if err == nil {
+		metrics = append(metrics, m)
+	} else {
+		c.logger.Error(
+			"failed to create metric",
+			zap.Error(err),
+			zap.String("metric", "collectionMetrics"),
+		)
+	}
+
+	return metrics
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	metrics := c.createMetrics()
+
+	for _, m := range metrics {
+		m := m
+		ch <- m
+	}
+
+	c.scrapeErrors.Collect(ch)
+	ch <- c.scrapeDuration
+	ch <- c.scrapeSuccess
+	c.sensorGauge.Collect(ch)
+	c.blowerGauge.Collect(ch)
+
+	if c.alerts != nil {
+		c.alerts.Collect(ch)
+	}
+}
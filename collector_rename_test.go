@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestRecordMetricsRenamedSensorDropsOldSeries verifies that a sensor
+// whose ID is unchanged but whose name (part of sensorGauge's label
+// set) changes between polls has its old series deleted, rather than
+// leaving both the old and new label combinations registered forever.
+func TestRecordMetricsRenamedSensorDropsOldSeries(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := "original_name"
+		if atomic.AddInt32(&calls, 1) > 1 {
+			name = "renamed"
+		}
+		fmt.Fprintf(w, `{"stoker":{"sensors":[{"id":"1","name":%q,"tc":10}],"blowers":[]}}`, name)
+	}))
+	defer srv.Close()
+
+	c, err := newCollector("test", srv.URL)
+	if err != nil {
+		t.Fatalf("newCollector: %v", err)
+	}
+
+	if err := c.recordMetrics(); err != nil {
+		t.Fatalf("recordMetrics (1st poll): %v", err)
+	}
+	if err := c.recordMetrics(); err != nil {
+		t.Fatalf("recordMetrics (2nd poll): %v", err)
+	}
+
+	if n := testutil.CollectAndCount(c.sensorGauge); n != 1 {
+		t.Fatalf("expected 1 sensor series after rename, got %d", n)
+	}
+}
+
+// TestRecordMetricsRemovedSensorDropsSeries verifies that a sensor
+// which disappears from the Stoker response stops being reported
+// instead of lingering at its last value.
+func TestRecordMetricsRemovedSensorDropsSeries(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			fmt.Fprint(w, `{"stoker":{"sensors":[{"id":"1","name":"a","tc":10},{"id":"2","name":"b","tc":20}],"blowers":[]}}`)
+			return
+		}
+		fmt.Fprint(w, `{"stoker":{"sensors":[{"id":"1","name":"a","tc":10}],"blowers":[]}}`)
+	}))
+	defer srv.Close()
+
+	c, err := newCollector("test", srv.URL)
+	if err != nil {
+		t.Fatalf("newCollector: %v", err)
+	}
+
+	if err := c.recordMetrics(); err != nil {
+		t.Fatalf("recordMetrics (1st poll): %v", err)
+	}
+	if err := c.recordMetrics(); err != nil {
+		t.Fatalf("recordMetrics (2nd poll): %v", err)
+	}
+
+	if n := testutil.CollectAndCount(c.sensorGauge); n != 1 {
+		t.Fatalf("expected 1 sensor series after removal, got %d", n)
+	}
+}
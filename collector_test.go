@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// roundTripperFunc lets a plain function satisfy http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// fakeNetError is a net.Error whose Timeout() return value is
+// configurable, used to distinguish transport timeouts from other
+// transport failures such as connection-refused.
+type fakeNetError struct {
+	timeout bool
+}
+
+func (e *fakeNetError) Error() string   { return "fake net error" }
+func (e *fakeNetError) Timeout() bool   { return e.timeout }
+func (e *fakeNetError) Temporary() bool { return false }
+
+var _ net.Error = (*fakeNetError)(nil)
+
+func TestGetStokerStatusClassifiesTimeoutVsNetworkErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantReason scrapeErrorReason
+	}{
+		{name: "timeout", err: &fakeNetError{timeout: true}, wantReason: reasonTimeout},
+		{name: "connection refused", err: &fakeNetError{timeout: false}, wantReason: reasonNetwork},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := newCollector("test", "http://example.invalid/stoker.json")
+			if err != nil {
+				t.Fatalf("newCollector: %v", err)
+			}
+			c.client = &http.Client{
+				Transport: roundTripperFunc(func(*http.Request) (*http.Response, error) {
+					return nil, tt.err
+				}),
+			}
+
+			_, scrapeErr := c.getStokerStatus()
+			if got := reasonFor(scrapeErr); got != tt.wantReason {
+				t.Fatalf("reasonFor: got %q, want %q", got, tt.wantReason)
+			}
+		})
+	}
+}
+
+// TestDeviceCollectorMultipleDevicesRegister verifies that a
+// deviceCollector with more than one device can be registered with
+// Prometheus without a duplicate-descriptor collision. collectionMetrics
+// previously carried no device label, so two devices produced identical
+// stoker_collections_total descriptors and registration/scraping failed.
+func TestDeviceCollectorMultipleDevicesRegister(t *testing.T) {
+	dc := &deviceCollector{}
+
+	for _, name := range []string{"north", "south"} {
+		c, err := newCollector(name, "http://example.invalid/stoker.json")
+		if err != nil {
+			t.Fatalf("newCollector(%q): %v", name, err)
+		}
+		dc.collectors = append(dc.collectors, c)
+	}
+
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(dc); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	if _, err := reg.Gather(); err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+}
+
+// TestDeviceCollectorMultipleDevicesAlertingRegister verifies that a
+// deviceCollector with more than one device, each with alerting
+// configured, can be registered and scraped without a duplicate-
+// descriptor collision. alertManager's activeMetric/firedMetric
+// previously carried no device label, so two devices both failing a
+// scrape (e.g. a shared network outage) produced identical
+// stoker_alert_active/stoker_alert_fired_total series and Gather failed.
+func TestDeviceCollectorMultipleDevicesAlertingRegister(t *testing.T) {
+	dc := &deviceCollector{}
+
+	for _, name := range []string{"north", "south"} {
+		c, err := newCollector(name, "http://example.invalid/stoker.json")
+		if err != nil {
+			t.Fatalf("newCollector(%q): %v", name, err)
+		}
+		c.alerts = newAlertManager(name, nil, time.Nanosecond, nil, c.logger)
+		start := time.Now()
+		c.alerts.checkScrape(false, start)
+		c.alerts.checkScrape(false, start.Add(time.Millisecond))
+		dc.collectors = append(dc.collectors, c)
+	}
+
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(dc); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	if _, err := reg.Gather(); err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+}
+
+// TestRecordMetricsDoesNotHoldLockOnSlowAlert verifies that recordMetrics
+// releases c.Mutex before notifying alert sinks. An alert notifier can
+// block for several seconds (e.g. a webhook POST to an unreachable
+// host); since every /metrics scrape and API handler also takes
+// c.Mutex, a notify call made while still holding it would stall them
+// too.
+func TestRecordMetricsDoesNotHoldLockOnSlowAlert(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"stoker":{"sensors":[{"id":"1","name":"a","tc":5}],"blowers":[]}}`)
+	}))
+	defer srv.Close()
+
+	c, err := newCollector("test", srv.URL)
+	if err != nil {
+		t.Fatalf("newCollector: %v", err)
+	}
+
+	minTemp := 50.0
+	release := make(chan struct{})
+	defer close(release)
+	blocker := &blockingNotifier{release: release}
+	c.alerts = newAlertManager("test", map[string]sensorThreshold{"1": {minTemp: &minTemp}}, 0, []notifier{blocker}, c.logger)
+
+	go c.recordMetrics()
+
+	// give recordMetrics time to update state and reach the blocked
+	// notifier call
+	time.Sleep(50 * time.Millisecond)
+
+	lockAcquired := make(chan struct{})
+	go func() {
+		c.Lock()
+		c.Unlock()
+		close(lockAcquired)
+	}()
+
+	select {
+	case <-lockAcquired:
+	case <-time.After(time.Second):
+		t.Fatal("collector lock was still held while an alert notifier was blocked")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// sensorAlertConfig configures alert thresholds for a single sensor.
+type sensorAlertConfig struct {
+	ID            string   `yaml:"id" json:"id"`
+	MinTemp       *float64 `yaml:"min_temp" json:"min_temp"`
+	MaxTemp       *float64 `yaml:"max_temp" json:"max_temp"`
+	StuckDuration string   `yaml:"stuck_duration" json:"stuck_duration"`
+}
+
+// deviceConfig identifies a single Stoker unit to monitor.
+type deviceConfig struct {
+	Name    string              `yaml:"name" json:"name"`
+	URL     string              `yaml:"url" json:"url"`
+	Sensors []sensorAlertConfig `yaml:"sensors" json:"sensors"`
+
+	// HistorySize is the number of samples kept in memory for this
+	// device, taken at the poll interval. Defaults to defaultHistorySize.
+	HistorySize int `yaml:"history_size" json:"history_size"`
+
+	// HistoryFile, if set, periodically receives the device's sample
+	// history as JSON so it survives a restart.
+	HistoryFile string `yaml:"history_file" json:"history_file"`
+
+	// HistoryFlushInterval controls how often HistoryFile is written,
+	// e.g. "1m". Defaults to defaultHistoryFlushInterval.
+	HistoryFlushInterval string `yaml:"history_flush_interval" json:"history_flush_interval"`
+}
+
+// fileConfig is the on-disk representation of the -config file.
+type fileConfig struct {
+	Devices []deviceConfig `yaml:"devices" json:"devices"`
+
+	// WebhookURL, if set, receives a JSON POST for every alert that fires.
+	WebhookURL string `yaml:"webhook_url" json:"webhook_url"`
+
+	// ScrapeFailureWindow is how long scraping a device may keep failing
+	// before a scrape_failure alert fires, e.g. "2m".
+	ScrapeFailureWindow string `yaml:"scrape_failure_window" json:"scrape_failure_window"`
+}
+
+// loadConfig reads and parses the device configuration file at path.
+// The file is treated as JSON if its extension is ".json", otherwise
+// it is parsed as YAML.
+func loadConfig(path string) (*fileConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read config file %q", path)
+	}
+
+	var cfg fileConfig
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal config file %q", path)
+		}
+	} else {
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal config file %q", path)
+		}
+	}
+
+	if len(cfg.Devices) == 0 {
+		return nil, errors.Errorf("config file %q has no devices configured", path)
+	}
+
+	return &cfg, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// configLoader produces the current fileConfig, either from the
+// -config file or the legacy -stoker flag.
+type configLoader func() (*fileConfig, error)
+
+// daemon owns the currently running deviceCollector and knows how to
+// swap it out for a freshly loaded configuration on reload, and how to
+// stop it cleanly on shutdown.
+type daemon struct {
+	sync.Mutex
+	loadConfig configLoader
+	logger     *zap.Logger
+	dc         *deviceCollector
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+func newDaemon(loadConfig configLoader, logger *zap.Logger) (*daemon, error) {
+	d := &daemon{
+		loadConfig: loadConfig,
+		logger:     logger,
+	}
+
+	if err := d.start(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// start loads the configuration, builds a deviceCollector, registers
+// it with Prometheus and begins its background polling loop.
+func (d *daemon) start() error {
+	cfg, err := d.loadConfig()
+	if err != nil {
+		return errors.Wrap(err, "failed to load config")
+	}
+
+	dc, err := newDeviceCollector(cfg)
+	if err != nil {
+		return errors.Wrap(err, "failed to create device collector")
+	}
+
+	if err := prometheus.Register(dc); err != nil {
+		return errors.Wrap(err, "failed to register metrics")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		dc.run(ctx)
+	}()
+
+	d.Lock()
+	d.dc = dc
+	d.cancel = cancel
+	d.done = done
+	d.Unlock()
+
+	return nil
+}
+
+// reload replaces the running deviceCollector with one built from the
+// latest configuration. If the new configuration fails to load or
+// build, the previous collector is left running untouched. The
+// previous collector's metrics are unregistered before the new one is
+// registered, so the two generations are never both live at once; if
+// registering the new collector fails, the previous one is restarted
+// so the daemon keeps monitoring with its last-known-good config.
+func (d *daemon) reload() {
+	d.logger.Info("reloading configuration")
+
+	cfg, err := d.loadConfig()
+	if err != nil {
+		d.logger.Error("failed to reload config, keeping previous configuration", zap.Error(err))
+		return
+	}
+
+	newDC, err := newDeviceCollector(cfg)
+	if err != nil {
+		d.logger.Error("failed to build device collector, keeping previous configuration", zap.Error(err))
+		return
+	}
+
+	d.Lock()
+	oldDC, oldCancel, oldDone := d.dc, d.cancel, d.done
+	d.Unlock()
+
+	oldCancel()
+	<-oldDone
+	prometheus.Unregister(oldDC)
+
+	if err := prometheus.Register(newDC); err != nil {
+		d.logger.Error("failed to register reloaded metrics, reverting to previous configuration", zap.Error(err))
+
+		if regErr := prometheus.Register(oldDC); regErr != nil {
+			d.logger.Error("failed to re-register previous configuration after a failed reload", zap.Error(regErr))
+			return
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+			oldDC.run(ctx)
+		}()
+
+		d.Lock()
+		d.dc, d.cancel, d.done = oldDC, cancel, done
+		d.Unlock()
+
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		newDC.run(ctx)
+	}()
+
+	d.Lock()
+	d.dc, d.cancel, d.done = newDC, cancel, done
+	d.Unlock()
+
+	d.logger.Info("configuration reloaded")
+}
+
+// shutdown stops the running deviceCollector's background loop and
+// waits for it to exit.
+func (d *daemon) shutdown() {
+	d.Lock()
+	cancel, done := d.cancel, d.done
+	d.Unlock()
+
+	cancel()
+	<-done
+}
+
+// current returns the deviceCollector currently in use. It changes
+// after every successful reload.
+func (d *daemon) current() *deviceCollector {
+	d.Lock()
+	defer d.Unlock()
+	return d.dc
+}
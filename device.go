@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// deviceCollector fans out scraping and metric collection across
+// multiple Stoker units so they can be monitored from a single
+// exporter process.
+type deviceCollector struct {
+	collectors []*collector
+}
+
+func newDeviceCollector(cfg *fileConfig) (*deviceCollector, error) {
+	if cfg == nil || len(cfg.Devices) == 0 {
+		return nil, errors.New("no devices configured")
+	}
+
+	scrapeFailureWindow, err := parseDurationOrZero(cfg.ScrapeFailureWindow)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid scrape_failure_window")
+	}
+
+	dc := &deviceCollector{
+		collectors: make([]*collector, 0, len(cfg.Devices)),
+	}
+
+	for _, d := range cfg.Devices {
+		c, err := newCollector(d.Name, d.URL)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create collector for device %q", d.Name)
+		}
+
+		thresholds, err := buildThresholds(d.Sensors)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid sensor thresholds for device %q", d.Name)
+		}
+
+		if len(thresholds) > 0 || scrapeFailureWindow > 0 {
+			notifiers := []notifier{&logNotifier{logger: c.logger}}
+			if cfg.WebhookURL != "" {
+				notifiers = append(notifiers, newWebhookNotifier(cfg.WebhookURL))
+			}
+			c.alerts = newAlertManager(d.Name, thresholds, scrapeFailureWindow, notifiers, c.logger)
+		}
+
+		flushInterval, err := parseDurationOrDefault(d.HistoryFlushInterval, defaultHistoryFlushInterval)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid history_flush_interval for device %q", d.Name)
+		}
+		c.history = newHistory(d.HistorySize, d.HistoryFile, flushInterval, c.logger)
+
+		dc.collectors = append(dc.collectors, c)
+	}
+
+	return dc, nil
+}
+
+func parseDurationOrZero(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// byName returns the collector for the named device, or the first
+// configured collector if name is empty. It returns nil if name does
+// not match any configured device.
+func (dc *deviceCollector) byName(name string) *collector {
+	if name == "" {
+		if len(dc.collectors) == 0 {
+			return nil
+		}
+		return dc.collectors[0]
+	}
+
+	for _, c := range dc.collectors {
+		if c.device == name {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func (dc *deviceCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range dc.collectors {
+		c.Describe(ch)
+	}
+}
+
+func (dc *deviceCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range dc.collectors {
+		c.Collect(ch)
+	}
+}
+
+// run starts the background polling loop for every device and blocks
+// until ctx is cancelled and all loops have returned.
+func (dc *deviceCollector) run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for _, c := range dc.collectors {
+		wg.Add(1)
+		go func(c *collector) {
+			defer wg.Done()
+			c.loop(ctx)
+		}(c)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultHistorySize          = 360 // 1 hour of samples at the default 10s poll interval
+	defaultHistoryFlushInterval = time.Minute
+)
+
+// historySample is one snapshot of every sensor's reading at a point
+// in time.
+type historySample struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Sensors   map[string]sensor `json:"sensors"`
+}
+
+// sensorHistoryPoint is a single sensor's reading at a point in time,
+// as returned by the /api/v1/sensors/{id}/history endpoint.
+type sensorHistoryPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Temp      float64   `json:"temp"`
+}
+
+// history is a fixed-size ring buffer of recent sensor samples for a
+// single device, with optional periodic persistence to a JSON file so
+// samples survive a restart.
+type history struct {
+	sync.Mutex
+	samples       []historySample
+	next          int
+	full          bool
+	persistPath   string
+	flushInterval time.Duration
+	lastFlush     time.Time
+	logger        *zap.Logger
+}
+
+func newHistory(size int, persistPath string, flushInterval time.Duration, logger *zap.Logger) *history {
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+
+	h := &history{
+		samples:       make([]historySample, size),
+		persistPath:   persistPath,
+		flushInterval: flushInterval,
+		logger:        logger,
+	}
+
+	if persistPath != "" {
+		if err := h.load(); err != nil && !os.IsNotExist(errors.Cause(err)) {
+			// a corrupt or unreadable history file should not prevent
+			// startup; just start with an empty history
+			h.samples = make([]historySample, size)
+			h.next = 0
+			h.full = false
+		}
+	}
+
+	return h
+}
+
+// add records a new sample, copying sensors so later mutation of the
+// caller's map does not affect stored history.
+func (h *history) add(sensors map[string]sensor, now time.Time) {
+	h.Lock()
+	defer h.Unlock()
+
+	cp := make(map[string]sensor, len(sensors))
+	for k, v := range sensors {
+		cp[k] = v
+	}
+
+	h.samples[h.next] = historySample{Timestamp: now, Sensors: cp}
+	h.next = (h.next + 1) % len(h.samples)
+	if h.next == 0 {
+		h.full = true
+	}
+
+	if h.persistPath != "" && now.Sub(h.lastFlush) >= h.flushInterval {
+		h.lastFlush = now
+		go func() {
+			if err := h.save(); err != nil {
+				h.logger.Error("failed to persist sensor history", zap.Error(err))
+			}
+		}()
+	}
+}
+
+// all returns every stored sample, oldest first.
+func (h *history) all() []historySample {
+	h.Lock()
+	defer h.Unlock()
+
+	out := make([]historySample, 0, len(h.samples))
+	if h.full {
+		out = append(out, h.samples[h.next:]...)
+	}
+	out = append(out, h.samples[:h.next]...)
+	return out
+}
+
+// since returns every stored sample with a timestamp after t.
+func (h *history) since(t time.Time) []historySample {
+	all := h.all()
+	out := make([]historySample, 0, len(all))
+	for _, s := range all {
+		if s.Timestamp.After(t) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// sensorSince returns id's readings, in samples with a timestamp
+// after t.
+func (h *history) sensorSince(id string, t time.Time) []sensorHistoryPoint {
+	samples := h.since(t)
+	points := make([]sensorHistoryPoint, 0, len(samples))
+	for _, s := range samples {
+		if v, ok := s.Sensors[id]; ok {
+			points = append(points, sensorHistoryPoint{Timestamp: s.Timestamp, Temp: v.Temp})
+		}
+	}
+	return points
+}
+
+// save writes the current history to persistPath as JSON.
+func (h *history) save() error {
+	if h.persistPath == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(h.all())
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal history")
+	}
+
+	if err := ioutil.WriteFile(h.persistPath, data, 0644); err != nil {
+		return errors.Wrapf(err, "failed to write history file %q", h.persistPath)
+	}
+
+	return nil
+}
+
+// load reads previously persisted samples from persistPath, if any.
+func (h *history) load() error {
+	data, err := ioutil.ReadFile(h.persistPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read history file %q", h.persistPath)
+	}
+
+	var samples []historySample
+	if err := json.Unmarshal(data, &samples); err != nil {
+		return errors.Wrapf(err, "failed to unmarshal history file %q", h.persistPath)
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	for _, s := range samples {
+		h.samples[h.next] = s
+		h.next = (h.next + 1) % len(h.samples)
+		if h.next == 0 {
+			h.full = true
+		}
+	}
+
+	return nil
+}
+
+func parseDurationOrDefault(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	return time.ParseDuration(s)
+}